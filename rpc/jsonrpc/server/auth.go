@@ -0,0 +1,56 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Identity represents an authenticated caller, as established by a
+// WebsocketManager's authenticator. Its concrete type is defined by the
+// authenticator; the server only stashes it on the wsConnection and
+// forwards it to the Authorizer.
+type Identity interface{}
+
+// Authorizer decides whether an already-authenticated caller may invoke
+// method. It is checked by wsConnection before every RPC dispatch, batched
+// or not, and runs after the WebsocketManager's authenticator has already
+// accepted the connection.
+type Authorizer func(id Identity, method string) error
+
+// CodeUnauthorized is the JSON-RPC error code returned to the caller when
+// an Authorizer rejects a method call.
+const CodeUnauthorized = -32001
+
+// ForbiddenError may be returned by a WebsocketManager's authenticator to
+// request an HTTP 403 Forbidden response at upgrade time, instead of the
+// default 401 Unauthorized.
+type ForbiddenError struct {
+	Err error
+}
+
+func (e *ForbiddenError) Error() string { return e.Err.Error() }
+func (e *ForbiddenError) Unwrap() error { return e.Err }
+
+// authenticateUpgrade runs authn (if set) against r and translates a
+// failure into the appropriate HTTP status, without hijacking the
+// connection. ok is false if the caller should stop handling the request.
+func authenticateUpgrade(
+	authn func(*http.Request) (Identity, error),
+	w http.ResponseWriter,
+	r *http.Request,
+) (id Identity, ok bool) {
+	if authn == nil {
+		return nil, true
+	}
+	id, err := authn(r)
+	if err != nil {
+		status := http.StatusUnauthorized
+		var forbidden *ForbiddenError
+		if errors.As(err, &forbidden) {
+			status = http.StatusForbidden
+		}
+		http.Error(w, "authentication failed: "+err.Error(), status)
+		return nil, false
+	}
+	return id, true
+}
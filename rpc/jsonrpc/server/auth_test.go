@@ -0,0 +1,131 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	rpctypes "github.com/tendermint/tendermint/rpc/jsonrpc/types"
+)
+
+func TestAuthenticateUpgradeNoAuthenticator(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/websocket", nil)
+
+	id, ok := authenticateUpgrade(nil, w, r)
+	require.True(t, ok)
+	require.Nil(t, id)
+}
+
+func TestAuthenticateUpgradeSuccess(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/websocket", nil)
+	wantIdentity := "alice"
+
+	id, ok := authenticateUpgrade(func(*http.Request) (Identity, error) {
+		return wantIdentity, nil
+	}, w, r)
+
+	require.True(t, ok)
+	require.Equal(t, Identity(wantIdentity), id)
+}
+
+func TestAuthenticateUpgradePlainErrorIsUnauthorized(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/websocket", nil)
+
+	id, ok := authenticateUpgrade(func(*http.Request) (Identity, error) {
+		return nil, errors.New("bad credentials")
+	}, w, r)
+
+	require.False(t, ok)
+	require.Nil(t, id)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestAuthenticateUpgradeForbiddenErrorIsForbidden(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/websocket", nil)
+
+	id, ok := authenticateUpgrade(func(*http.Request) (Identity, error) {
+		return nil, &ForbiddenError{Err: errors.New("blocked")}
+	}, w, r)
+
+	require.False(t, ok)
+	require.Nil(t, id)
+	require.Equal(t, http.StatusForbidden, w.Code)
+}
+
+// TestAuthenticateUpgradeWrappedForbiddenErrorIsForbidden is a regression
+// test for the type-assertion bug fixed by switching to errors.As: an
+// authenticator that wraps *ForbiddenError (e.g. "ldap: %w") must still get
+// a 403, not the default 401.
+func TestAuthenticateUpgradeWrappedForbiddenErrorIsForbidden(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/websocket", nil)
+
+	id, ok := authenticateUpgrade(func(*http.Request) (Identity, error) {
+		return nil, fmt.Errorf("ldap: %w", &ForbiddenError{Err: errors.New("blocked")})
+	}, w, r)
+
+	require.False(t, ok)
+	require.Nil(t, id)
+	require.Equal(t, http.StatusForbidden, w.Code)
+}
+
+// TestWSConnectionIdentity checks the Identity() getter RPC handlers use
+// (via rpctypes.Context.WSConn) to recover the caller identity established
+// at upgrade time.
+func TestWSConnectionIdentity(t *testing.T) {
+	wsc := newTestWSConnection()
+	wsc.identity = "alice"
+
+	require.Equal(t, Identity("alice"), wsc.Identity())
+}
+
+// TestHandleRequestAuthorizerRejection checks that a rejecting Authorizer
+// short-circuits dispatch with a CodeUnauthorized response, and that it is
+// handed the identity stashed on the connection by the authenticator.
+func TestHandleRequestAuthorizerRejection(t *testing.T) {
+	wsc := newTestWSConnection()
+	wsc.identity = "alice"
+	wsc.funcMap["restricted"] = &RPCFunc{}
+
+	var gotIdentity Identity
+	wsc.authorizer = func(id Identity, method string) error {
+		gotIdentity = id
+		return fmt.Errorf("%s may not call %s", id, method)
+	}
+
+	resp := wsc.handleRequest(rpctypes.RPCRequest{
+		JSONRPC: "2.0",
+		ID:      rpctypes.JSONRPCIntID(1),
+		Method:  "restricted",
+	})
+
+	require.Equal(t, Identity("alice"), gotIdentity)
+	require.NotNil(t, resp)
+	require.NotNil(t, resp.Error)
+	require.Equal(t, CodeUnauthorized, resp.Error.Code)
+}
+
+// TestHandleRequestNoAuthorizerUnknownMethod checks that, when no Authorizer
+// is configured, a request for an unknown method gets an error response
+// whose code is not CodeUnauthorized.
+func TestHandleRequestNoAuthorizerUnknownMethod(t *testing.T) {
+	wsc := newTestWSConnection()
+
+	resp := wsc.handleRequest(rpctypes.RPCRequest{
+		JSONRPC: "2.0",
+		ID:      rpctypes.JSONRPCIntID(1),
+		Method:  "unknown",
+	})
+
+	require.NotNil(t, resp)
+	require.NotNil(t, resp.Error)
+	require.NotEqual(t, CodeUnauthorized, resp.Error.Code)
+}
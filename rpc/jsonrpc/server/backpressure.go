@@ -0,0 +1,162 @@
+package server
+
+import (
+	"time"
+
+	rpctypes "github.com/tendermint/tendermint/rpc/jsonrpc/types"
+)
+
+// BackpressureMode identifies how a wsConnection behaves when writeChan
+// can't keep up with outgoing responses.
+type BackpressureMode int
+
+const (
+	// backpressureBlock blocks WriteRPCResponse until the response is
+	// accepted or the context is done, optionally bounded by an extra
+	// timeout. This is the default, preserving the historical behavior.
+	backpressureBlock BackpressureMode = iota
+	// backpressureDropOldest never blocks: it pops the oldest queued
+	// response to make room for the new one, turning writeChan into a
+	// ring buffer.
+	backpressureDropOldest
+	// backpressureDisconnectSlow never blocks: responses are dropped while
+	// writeChan is full, and the connection is disconnected once it has
+	// stayed at or above a threshold for longer than a grace period.
+	backpressureDisconnectSlow
+)
+
+// BackpressurePolicyConfig configures what a wsConnection does when its write
+// channel can't keep up with outgoing responses. Build one with
+// BlockWithTimeout, DropOldest or DisconnectSlow and pass it to the
+// BackpressurePolicy option.
+type BackpressurePolicyConfig struct {
+	mode BackpressureMode
+
+	// set by BlockWithTimeout; 0 means no extra timeout beyond the caller's
+	// context.
+	timeout time.Duration
+
+	// set by DisconnectSlow.
+	threshold int
+	grace     time.Duration
+}
+
+// BlockWithTimeout makes WriteRPCResponse block until the response is
+// accepted, the caller's context is done, or d elapses, whichever comes
+// first. d <= 0 means block only on the caller's context, matching the
+// historical default.
+func BlockWithTimeout(d time.Duration) BackpressurePolicyConfig {
+	return BackpressurePolicyConfig{mode: backpressureBlock, timeout: d}
+}
+
+// DropOldest makes writeChan behave like a ring buffer: when full, the
+// oldest queued response is popped to make room for the new one, so
+// WriteRPCResponse and TryWriteRPCResponse never block.
+func DropOldest() BackpressurePolicyConfig {
+	return BackpressurePolicyConfig{mode: backpressureDropOldest}
+}
+
+// DisconnectSlow makes wsConnection drop responses while writeChan is full,
+// and disconnect the client once its queue depth has stayed at or above
+// threshold for longer than grace.
+func DisconnectSlow(threshold int, grace time.Duration) BackpressurePolicyConfig {
+	return BackpressurePolicyConfig{mode: backpressureDisconnectSlow, threshold: threshold, grace: grace}
+}
+
+// BackpressurePolicy sets the policy a wsConnection applies when writeChan
+// can't keep up with outgoing responses. It should only be used in the
+// constructor - not Goroutine-safe. Defaults to BlockWithTimeout(0), i.e.
+// the historical behavior of blocking until accepted or canceled.
+func BackpressurePolicy(policy BackpressurePolicyConfig) func(*wsConnection) {
+	return func(wsc *wsConnection) {
+		wsc.backpressure = policy
+	}
+}
+
+// OnSlowConsumer sets a callback invoked when a wsConnection disconnects
+// the client under BackpressureMode DisconnectSlow, passing the remote
+// address and the write queue depth observed at disconnect time. This lets
+// callers (e.g. the event subsystem) drop the client's subscriptions
+// cleanly. It should only be used in the constructor - not Goroutine-safe.
+func OnSlowConsumer(f func(remoteAddr string, queueDepth int)) func(*wsConnection) {
+	return func(wsc *wsConnection) {
+		wsc.onSlowConsumer = f
+	}
+}
+
+// pushDropOldest enqueues resp onto writeChan, popping the oldest queued
+// response first if writeChan is full. It never blocks.
+func (wsc *wsConnection) pushDropOldest(resp rpctypes.RPCResponse) {
+	for {
+		select {
+		case wsc.writeChan <- resp:
+			wsc.metrics.WriteChanDepth.Update(int64(len(wsc.writeChan)))
+			return
+		default:
+		}
+		select {
+		case <-wsc.writeChan:
+			wsc.metrics.DroppedResponses.Inc(1)
+		default:
+			// writeChan was drained concurrently; retry the enqueue.
+		}
+	}
+}
+
+// pushDropOldestBatch enqueues resps onto batchWriteChan, popping the oldest
+// queued batch first if batchWriteChan is full. It never blocks.
+func (wsc *wsConnection) pushDropOldestBatch(resps []rpctypes.RPCResponse) {
+	for {
+		select {
+		case wsc.batchWriteChan <- resps:
+			wsc.metrics.WriteChanDepth.Update(int64(len(wsc.batchWriteChan)))
+			return
+		default:
+		}
+		select {
+		case <-wsc.batchWriteChan:
+			wsc.metrics.DroppedResponses.Inc(1)
+		default:
+			// batchWriteChan was drained concurrently; retry the enqueue.
+		}
+	}
+}
+
+// checkSlowConsumer records whether writeChan is currently at or above the
+// DisconnectSlow threshold, and disconnects the client once it has stayed
+// there for longer than the configured grace period.
+func (wsc *wsConnection) checkSlowConsumer(depth int) {
+	wsc.backpressureMu.Lock()
+	defer wsc.backpressureMu.Unlock()
+
+	if depth < wsc.backpressure.threshold {
+		wsc.slowSince = time.Time{}
+		return
+	}
+	if wsc.slowSince.IsZero() {
+		wsc.slowSince = time.Now()
+		return
+	}
+	if time.Since(wsc.slowSince) >= wsc.backpressure.grace {
+		wsc.slowSince = time.Time{}
+		wsc.disconnectSlow(depth)
+	}
+}
+
+// disconnectSlow tears down a connection that has been a slow consumer for
+// too long under BackpressureMode DisconnectSlow.
+func (wsc *wsConnection) disconnectSlow(depth int) {
+	wsc.metrics.SlowConsumerDisconnects.Inc(1)
+	wsc.Logger.Error("disconnecting slow consumer", "remote", wsc.remoteAddr, "queue_depth", depth)
+	if wsc.onSlowConsumer != nil {
+		wsc.onSlowConsumer(wsc.remoteAddr, depth)
+	}
+	// Cancel the connection's context so that anything selecting on it
+	// (e.g. subscription forwarders) stops promptly, then close the
+	// underlying socket to unblock readRoutine/writeRoutine.
+	wsc.Context()
+	wsc.cancel()
+	if err := wsc.baseConn.Close(); err != nil {
+		wsc.Logger.Error("error closing slow consumer connection", "err", err)
+	}
+}
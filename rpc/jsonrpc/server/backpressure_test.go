@@ -0,0 +1,179 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+
+	rpctypes "github.com/tendermint/tendermint/rpc/jsonrpc/types"
+)
+
+// newTestBaseConn dials a real websocket connection against an in-process
+// httptest server, so tests that exercise code paths touching baseConn
+// (e.g. disconnectSlow's baseConn.Close()) have something real to call.
+func newTestBaseConn(t *testing.T) *websocket.Conn {
+	t.Helper()
+	var upgrader websocket.Upgrader
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		<-r.Context().Done()
+		conn.Close()
+	}))
+	t.Cleanup(srv.Close)
+
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// TestPushDropOldestEvictsOldest checks that pushDropOldest turns writeChan
+// into a ring buffer: pushing past capacity drops the oldest queued
+// response rather than blocking.
+func TestPushDropOldestEvictsOldest(t *testing.T) {
+	wsc := newTestWSConnection()
+	wsc.writeChan = make(chan rpctypes.RPCResponse, 1)
+	wsc.backpressure = DropOldest()
+
+	first := rpctypes.NewRPCSuccessResponse(rpctypes.JSONRPCIntID(1), "first")
+	second := rpctypes.NewRPCSuccessResponse(rpctypes.JSONRPCIntID(2), "second")
+
+	wsc.pushDropOldest(first)
+	wsc.pushDropOldest(second)
+
+	require.Len(t, wsc.writeChan, 1)
+	got := <-wsc.writeChan
+	require.Equal(t, second, got)
+}
+
+// TestPushDropOldestBatchEvictsOldest is the batchWriteChan counterpart of
+// TestPushDropOldestEvictsOldest.
+func TestPushDropOldestBatchEvictsOldest(t *testing.T) {
+	wsc := newTestWSConnection()
+	wsc.batchWriteChan = make(chan []rpctypes.RPCResponse, 1)
+	wsc.backpressure = DropOldest()
+
+	first := []rpctypes.RPCResponse{rpctypes.NewRPCSuccessResponse(rpctypes.JSONRPCIntID(1), "first")}
+	second := []rpctypes.RPCResponse{rpctypes.NewRPCSuccessResponse(rpctypes.JSONRPCIntID(2), "second")}
+
+	wsc.pushDropOldestBatch(first)
+	wsc.pushDropOldestBatch(second)
+
+	require.Len(t, wsc.batchWriteChan, 1)
+	got := <-wsc.batchWriteChan
+	require.Equal(t, second, got)
+}
+
+// TestDisconnectSlowTripsAfterGrace checks that a connection whose write
+// queue depth has stayed at or above the DisconnectSlow threshold for
+// longer than the grace period gets disconnected, with OnSlowConsumer
+// called with the connection's remote address and the observed depth.
+func TestDisconnectSlowTripsAfterGrace(t *testing.T) {
+	wsc := newTestWSConnection()
+	wsc.baseConn = newTestBaseConn(t)
+	wsc.remoteAddr = "1.2.3.4:5678"
+	const (
+		threshold = 5
+		grace     = 20 * time.Millisecond
+	)
+	wsc.backpressure = DisconnectSlow(threshold, grace)
+
+	var (
+		mu               sync.Mutex
+		gotAddr          string
+		gotDepth         int
+		disconnectCalled bool
+	)
+	wsc.onSlowConsumer = func(remoteAddr string, depth int) {
+		mu.Lock()
+		defer mu.Unlock()
+		gotAddr = remoteAddr
+		gotDepth = depth
+		disconnectCalled = true
+	}
+
+	// First observation at/above threshold starts the grace timer; it must
+	// not disconnect immediately.
+	wsc.checkSlowConsumer(threshold)
+	mu.Lock()
+	require.False(t, disconnectCalled)
+	mu.Unlock()
+
+	time.Sleep(grace + 10*time.Millisecond)
+
+	// Second observation, past grace, must trip the disconnect.
+	wsc.checkSlowConsumer(threshold)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.True(t, disconnectCalled)
+	require.Equal(t, wsc.remoteAddr, gotAddr)
+	require.Equal(t, threshold, gotDepth)
+}
+
+// TestDisconnectSlowResetsBelowThreshold checks that an observation below
+// the threshold resets the grace timer, so a connection that only briefly
+// dips into slow-consumer territory isn't disconnected.
+func TestDisconnectSlowResetsBelowThreshold(t *testing.T) {
+	wsc := newTestWSConnection()
+	wsc.baseConn = newTestBaseConn(t)
+	const (
+		threshold = 5
+		grace     = 20 * time.Millisecond
+	)
+	wsc.backpressure = DisconnectSlow(threshold, grace)
+
+	disconnected := false
+	wsc.onSlowConsumer = func(string, int) { disconnected = true }
+
+	wsc.checkSlowConsumer(threshold)
+	wsc.checkSlowConsumer(threshold - 1) // drops back below threshold, resets slowSince
+	time.Sleep(grace + 10*time.Millisecond)
+	wsc.checkSlowConsumer(threshold)
+
+	// The most recent above-threshold observation is fresh, so grace hasn't
+	// elapsed since it started.
+	require.False(t, disconnected)
+}
+
+// TestWSConnectionContextConcurrentInit is a -race regression test for the
+// Context() lazy-init fix: concurrent first-callers (e.g. a subscription
+// forwarder in readRoutine racing a publisher's checkSlowConsumer) must all
+// observe the same context, created exactly once.
+func TestWSConnectionContextConcurrentInit(t *testing.T) {
+	wsc := newTestWSConnection()
+
+	const n = 20
+	ctxs := make([]context.Context, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctxs[i] = wsc.Context()
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 1; i < n; i++ {
+		require.Same(t, ctxs[0], ctxs[i])
+	}
+
+	wsc.cancel()
+	select {
+	case <-wsc.ctx.Done():
+	default:
+		t.Fatal("expected context to be canceled")
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/tendermint/tendermint/libs/log"
+	"github.com/tendermint/tendermint/rpc/client"
+	rpctypes "github.com/tendermint/tendermint/rpc/jsonrpc/types"
+)
+
+// newTestWSConnection builds a wsConnection bypassing the websocket.Conn
+// dance in newWSConnection, for exercising handleBatchRequest directly.
+func newTestWSConnection() *wsConnection {
+	return &wsConnection{
+		RunState:       client.NewRunState("wsConnection", log.NewNopLogger()),
+		writeChan:      make(chan rpctypes.RPCResponse, 1),
+		batchWriteChan: make(chan []rpctypes.RPCResponse, 1),
+		metrics:        NopMetrics(),
+		funcMap:        map[string]*RPCFunc{},
+	}
+}
+
+// TestHandleBatchRequestEmptyBatch checks the JSON-RPC 2.0 spec requirement
+// that a syntactically empty batch ("[]") gets a single Invalid Request
+// response, distinct from a batch of only notifications, which gets none.
+func TestHandleBatchRequestEmptyBatch(t *testing.T) {
+	wsc := newTestWSConnection()
+
+	wsc.handleBatchRequest(context.Background(), []rpctypes.RPCRequest{})
+
+	select {
+	case resp := <-wsc.writeChan:
+		require.NotNil(t, resp.Error)
+	default:
+		t.Fatal("expected an Invalid Request response for an empty batch, got none")
+	}
+}
+
+// TestHandleBatchRequestAllNotifications checks that a batch made up
+// entirely of notifications (no "id") produces no response at all.
+func TestHandleBatchRequestAllNotifications(t *testing.T) {
+	wsc := newTestWSConnection()
+
+	wsc.handleBatchRequest(context.Background(), []rpctypes.RPCRequest{
+		{JSONRPC: "2.0", Method: "subscribe"},
+	})
+
+	select {
+	case resp := <-wsc.writeChan:
+		t.Fatalf("expected no response for an all-notification batch, got %v", resp)
+	default:
+	}
+}
+
+// TestHandleBatchRequestExceedsLimit checks that a batch larger than
+// BatchLimit is rejected with a single Invalid Request response instead of
+// being dispatched.
+func TestHandleBatchRequestExceedsLimit(t *testing.T) {
+	wsc := newTestWSConnection()
+	wsc.batchLimit = 2
+	wsc.funcMap["ok"] = &RPCFunc{f: reflect.ValueOf(func(ctx *rpctypes.Context) (string, error) {
+		return "fine", nil
+	})}
+
+	requests := []rpctypes.RPCRequest{
+		{JSONRPC: "2.0", ID: rpctypes.JSONRPCIntID(1), Method: "ok"},
+		{JSONRPC: "2.0", ID: rpctypes.JSONRPCIntID(2), Method: "ok"},
+		{JSONRPC: "2.0", ID: rpctypes.JSONRPCIntID(3), Method: "ok"},
+	}
+
+	wsc.handleBatchRequest(context.Background(), requests)
+
+	select {
+	case resp := <-wsc.writeChan:
+		require.NotNil(t, resp.Error)
+	default:
+		t.Fatal("expected an Invalid Request response for an over-limit batch, got none")
+	}
+	select {
+	case resps := <-wsc.batchWriteChan:
+		t.Fatalf("expected the over-limit batch not to be dispatched, got %v", resps)
+	default:
+	}
+}
+
+// TestHandleBatchRequestPanicDoesNotPoisonSiblings checks handleRequest's
+// own recover: one request in a batch panicking must not prevent its
+// siblings from being dispatched and answered.
+func TestHandleBatchRequestPanicDoesNotPoisonSiblings(t *testing.T) {
+	wsc := newTestWSConnection()
+	wsc.funcMap["boom"] = &RPCFunc{f: reflect.ValueOf(func(ctx *rpctypes.Context) (string, error) {
+		panic("boom")
+	})}
+	wsc.funcMap["ok"] = &RPCFunc{f: reflect.ValueOf(func(ctx *rpctypes.Context) (string, error) {
+		return "fine", nil
+	})}
+
+	requests := []rpctypes.RPCRequest{
+		{JSONRPC: "2.0", ID: rpctypes.JSONRPCIntID(1), Method: "boom"},
+		{JSONRPC: "2.0", ID: rpctypes.JSONRPCIntID(2), Method: "ok"},
+	}
+
+	wsc.handleBatchRequest(context.Background(), requests)
+
+	select {
+	case resps := <-wsc.batchWriteChan:
+		require.Len(t, resps, 2)
+		require.NotNil(t, resps[0].Error, "the panicking call should recover into an error response")
+		require.Nil(t, resps[1].Error, "its sibling should still dispatch successfully")
+	default:
+		t.Fatal("expected a batch response covering both requests")
+	}
+}
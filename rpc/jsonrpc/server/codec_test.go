@@ -0,0 +1,82 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/stretchr/testify/require"
+
+	rpctypes "github.com/tendermint/tendermint/rpc/jsonrpc/types"
+)
+
+// TestCBORCodecRequestIDRoundTrip exercises the decode path a real client
+// exercises: a non-notification request, with either ID shape, must come
+// back out as the same concrete jsonrpcid rather than failing to unmarshal
+// or silently losing the ID.
+func TestCBORCodecRequestIDRoundTrip(t *testing.T) {
+	codec := cborCodec{}
+
+	cases := []struct {
+		name string
+		id   interface{}
+	}{
+		{"int id", rpctypes.JSONRPCIntID(7)},
+		{"string id", rpctypes.JSONRPCStringID("abc")},
+		{"notification", nil},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := cbor.Marshal(cborRequestWire{
+				JSONRPC: "2.0",
+				ID:      newCBORIDWire(tc.id),
+				Method:  "subscribe",
+			})
+			require.NoError(t, err)
+
+			var decoded rpctypes.RPCRequest
+			require.NoError(t, codec.Unmarshal(data, &decoded))
+			require.Equal(t, tc.id, decoded.ID)
+			require.Equal(t, "subscribe", decoded.Method)
+		})
+	}
+}
+
+// TestCBORCodecResponseIDRoundTrip exercises the encode path: a response's
+// ID, of either shape, must survive being marshaled by the codec and
+// decoded back off the wire.
+func TestCBORCodecResponseIDRoundTrip(t *testing.T) {
+	codec := cborCodec{}
+
+	cases := []struct {
+		name string
+		id   interface{}
+	}{
+		{"int id", rpctypes.JSONRPCIntID(7)},
+		{"string id", rpctypes.JSONRPCStringID("abc")},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			var resp rpctypes.RPCResponse
+			switch v := tc.id.(type) {
+			case rpctypes.JSONRPCStringID:
+				resp = rpctypes.NewRPCSuccessResponse(v, "ok")
+			case rpctypes.JSONRPCIntID:
+				resp = rpctypes.NewRPCSuccessResponse(v, "ok")
+			}
+
+			data, err := codec.Marshal(resp)
+			require.NoError(t, err)
+
+			var wire cborResponseWire
+			require.NoError(t, cbor.Unmarshal(data, &wire))
+
+			var decoded rpctypes.RPCRequest
+			(cborRequestWire{ID: wire.ID}).into(&decoded)
+			require.Equal(t, tc.id, decoded.ID)
+		})
+	}
+}
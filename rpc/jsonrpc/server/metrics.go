@@ -0,0 +1,149 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// MetricsSubsystem is the prefix given to WS server metric names, matching
+// the convention used by the client-side rpc/jsonrpc/client metrics.
+const MetricsSubsystem = "ws_server"
+
+// Metrics contains the telemetry collected for a WebsocketManager and the
+// wsConnections it spawns. It wraps a go-metrics Registry so that the
+// caller can mount it on Prometheus (or any other backend) via a
+// translator, the same way the client-side metrics are exposed.
+type Metrics struct {
+	reg metrics.Registry
+
+	// ActiveConnections is the number of currently open websocket
+	// connections.
+	ActiveConnections metrics.Gauge
+
+	// Upgrades counts successful HTTP->WS upgrades.
+	Upgrades metrics.Counter
+	// Disconnects counts connections that have been torn down, for any
+	// reason (client close, read/write error, slow-consumer policy, ...).
+	Disconnects metrics.Counter
+	// Panics counts recovered panics in readRoutine handlers.
+	Panics metrics.Counter
+
+	// byCodeMu guards requestsByMethod and errorsByCode, which are created
+	// lazily from wsConnection goroutines (one per connection) sharing this
+	// Metrics instance.
+	byCodeMu sync.Mutex
+	// requestsByMethod is keyed per RPC method, e.g. "ws_server.requests.subscribe".
+	requestsByMethod map[string]metrics.Counter
+	// errorsByCode is keyed per JSON-RPC error code.
+	errorsByCode map[int]metrics.Counter
+
+	// RequestLatency measures the time spent inside rpcFunc.f.Call.
+	RequestLatency metrics.Timer
+
+	// WriteChanDepth samples the writeChan length every time a response is
+	// enqueued, so operators can see backpressure build up.
+	WriteChanDepth metrics.Histogram
+
+	// DroppedResponses counts responses dropped because writeChan was full,
+	// whether from TryWriteRPCResponse or from a BackpressureMode that
+	// drops rather than blocks.
+	DroppedResponses metrics.Counter
+
+	// SlowConsumerDisconnects counts connections closed by BackpressureMode
+	// DisconnectSlow because writeChan stayed saturated past its grace
+	// period.
+	SlowConsumerDisconnects metrics.Counter
+
+	// PongTimeouts counts connections closed because no pong was seen
+	// within readWait.
+	PongTimeouts metrics.Counter
+	// PingWriteErrors counts failed attempts to write a ping frame.
+	PingWriteErrors metrics.Counter
+}
+
+// NewMetrics returns Metrics that record into reg, or into a fresh registry
+// if reg is nil. The per-method request counters and per-code error counters
+// are created lazily as new methods/codes are observed; all other metrics
+// are registered here.
+func NewMetrics(reg metrics.Registry) *Metrics {
+	if reg == nil {
+		reg = metrics.NewRegistry()
+	}
+	return &Metrics{
+		reg:                     reg,
+		ActiveConnections:       metrics.NewRegisteredGauge(MetricsSubsystem+".active_connections", reg),
+		Upgrades:                metrics.NewRegisteredCounter(MetricsSubsystem+".upgrades", reg),
+		Disconnects:             metrics.NewRegisteredCounter(MetricsSubsystem+".disconnects", reg),
+		Panics:                  metrics.NewRegisteredCounter(MetricsSubsystem+".panics", reg),
+		requestsByMethod:        make(map[string]metrics.Counter),
+		errorsByCode:            make(map[int]metrics.Counter),
+		RequestLatency:          metrics.NewRegisteredTimer(MetricsSubsystem+".request_latency", reg),
+		WriteChanDepth:          metrics.NewRegisteredHistogram(MetricsSubsystem+".write_chan_depth", reg, metrics.NewExpDecaySample(1028, 0.015)),
+		DroppedResponses:        metrics.NewRegisteredCounter(MetricsSubsystem+".dropped_responses", reg),
+		SlowConsumerDisconnects: metrics.NewRegisteredCounter(MetricsSubsystem+".slow_consumer_disconnects", reg),
+		PongTimeouts:            metrics.NewRegisteredCounter(MetricsSubsystem+".pong_timeouts", reg),
+		PingWriteErrors:         metrics.NewRegisteredCounter(MetricsSubsystem+".ping_write_errors", reg),
+	}
+}
+
+// NopMetrics returns Metrics backed by a fresh, private registry, for use
+// when the caller does not want telemetry. Values are still recorded, but
+// only into that registry, which is reachable solely through Registry.
+func NopMetrics() *Metrics {
+	return NewMetrics(nil)
+}
+
+// Registry returns the underlying go-metrics registry, so callers can mount
+// it on Prometheus via a translator (e.g. go-metrics-prometheus).
+func (m *Metrics) Registry() metrics.Registry {
+	return m.reg
+}
+
+func (m *Metrics) requestCounter(method string) metrics.Counter {
+	m.byCodeMu.Lock()
+	defer m.byCodeMu.Unlock()
+	if c, ok := m.requestsByMethod[method]; ok {
+		return c
+	}
+	c := metrics.NewRegisteredCounter(MetricsSubsystem+".requests."+method, m.reg)
+	m.requestsByMethod[method] = c
+	return c
+}
+
+func (m *Metrics) errorCounter(code int) metrics.Counter {
+	m.byCodeMu.Lock()
+	defer m.byCodeMu.Unlock()
+	if c, ok := m.errorsByCode[code]; ok {
+		return c
+	}
+	c := metrics.NewRegisteredCounter(MetricsSubsystem+".errors."+strconv.Itoa(code), m.reg)
+	m.errorsByCode[code] = c
+	return c
+}
+
+// MarkRequest records that method was dispatched.
+func (m *Metrics) MarkRequest(method string) {
+	m.requestCounter(method).Inc(1)
+}
+
+// MarkError records an RPC error response with the given JSON-RPC code.
+func (m *Metrics) MarkError(code int) {
+	m.errorCounter(code).Inc(1)
+}
+
+// MarkRequestLatency records the time spent executing an RPC handler.
+func (m *Metrics) MarkRequestLatency(d time.Duration) {
+	m.RequestLatency.Update(d)
+}
+
+// hashRemoteAddr hashes a connection's remote address so it is cheap to
+// attach as a label without leaking raw client IPs into metrics storage.
+func hashRemoteAddr(remoteAddr string) string {
+	sum := sha256.Sum256([]byte(remoteAddr))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMetricsSharedAcrossConnections mirrors what WebsocketManager.WithMetrics
+// + WebsocketHandler do: build one Metrics and hand it to multiple
+// connections, concurrently, the way concurrent upgrades would. Regression
+// test for building Metrics per connection, which silently orphaned every
+// connection after the first.
+func TestMetricsSharedAcrossConnections(t *testing.T) {
+	reg := metrics.NewRegistry()
+	m := NewMetrics(reg)
+
+	const connections = 8
+	var wg sync.WaitGroup
+	for i := 0; i < connections; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m.MarkRequest("subscribe")
+			m.MarkError(-32000) // exercise a per-code counter concurrently
+		}(i)
+	}
+	wg.Wait()
+
+	c := reg.Get(MetricsSubsystem + ".requests.subscribe")
+	require.NotNil(t, c)
+	require.Equal(t, int64(connections), c.(metrics.Counter).Count())
+}
+
+// TestMetricsErrorCounterConcurrentAccess exercises errorCounter (via
+// MarkError) from many goroutines at once, as happens when every
+// wsConnection's readRoutine shares one Metrics. Run with -race.
+func TestMetricsErrorCounterConcurrentAccess(t *testing.T) {
+	m := NewMetrics(metrics.NewRegistry())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(code int) {
+			defer wg.Done()
+			m.MarkError(code % 5)
+		}(i)
+	}
+	wg.Wait()
+}
@@ -1,16 +1,20 @@
 package server
 
 import (
+	"compress/flate"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"reflect"
 	"runtime/debug"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/rcrowley/go-metrics"
 
 	"github.com/tendermint/tendermint/libs/log"
 	"github.com/tendermint/tendermint/rpc/client"
@@ -36,6 +40,11 @@ type WebsocketManager struct {
 	funcMap       map[string]*RPCFunc
 	logger        log.Logger
 	wsConnOptions []func(*wsConnection)
+
+	authenticator func(*http.Request) (Identity, error)
+	authorizer    Authorizer
+
+	metrics *Metrics
 }
 
 // NewWebsocketManager returns a new WebsocketManager that passes a map of
@@ -59,9 +68,18 @@ func NewWebsocketManager(
 				// mystery
 				return true
 			},
+			// EnableCompression negotiates permessage-deflate with clients
+			// that request it; clients that don't ask for it are unaffected.
+			EnableCompression: true,
+			// Subprotocols lists the wire formats this manager can speak, in
+			// order of preference. Clients that don't request one of these
+			// (e.g. older clients unaware of Sec-WebSocket-Protocol) fall
+			// back to plain JSON, the historical default.
+			Subprotocols: []string{subprotocolJSON, subprotocolCBOR},
 		},
 		logger:        log.NewNopLogger(),
 		wsConnOptions: wsConnOptions,
+		metrics:       NopMetrics(),
 	}
 }
 
@@ -70,9 +88,44 @@ func (wm *WebsocketManager) SetLogger(l log.Logger) {
 	wm.logger = l
 }
 
+// WithAuthenticator sets a function that is run during WebsocketHandler,
+// before Upgrade, to authenticate the incoming request. On failure the
+// handler responds with HTTP 401 Unauthorized (or 403 Forbidden, if the
+// error is a *ForbiddenError) without hijacking the connection. It should
+// only be used right after construction - not Goroutine-safe.
+func (wm *WebsocketManager) WithAuthenticator(authn func(*http.Request) (Identity, error)) {
+	wm.authenticator = authn
+}
+
+// WithAuthorizer sets a function that is checked before every RPC dispatch,
+// batched or not, to decide whether an already-authenticated caller may
+// invoke a given method. On failure, an RPC error with CodeUnauthorized is
+// returned to the caller instead of invoking the method. It should only be
+// used right after construction - not Goroutine-safe.
+func (wm *WebsocketManager) WithAuthorizer(authz Authorizer) {
+	wm.authorizer = authz
+}
+
+// WithMetrics sets the metrics that this manager and every wsConnection it
+// spawns record into. reg is a go-metrics Registry, following the same
+// registry pattern used by the RPC client; callers can mount it on
+// Prometheus via a translator. The Metrics is built once, here, and shared
+// by every connection - building it per connection would re-register its
+// gauges/counters on every upgrade, silently orphaning all but the first
+// connection's. It should only be used right after construction - not
+// Goroutine-safe.
+func (wm *WebsocketManager) WithMetrics(reg metrics.Registry) {
+	wm.metrics = NewMetrics(reg)
+}
+
 // WebsocketHandler upgrades the request/response (via http.Hijack) and starts
 // the wsConnection.
 func (wm *WebsocketManager) WebsocketHandler(w http.ResponseWriter, r *http.Request) {
+	identity, ok := authenticateUpgrade(wm.authenticator, w, r)
+	if !ok {
+		return
+	}
+
 	wsConn, err := wm.Upgrade(w, r, nil)
 	if err != nil {
 		// TODO - return http error
@@ -86,8 +139,19 @@ func (wm *WebsocketManager) WebsocketHandler(w http.ResponseWriter, r *http.Requ
 	}()
 
 	// register connection
-	logger := wm.logger.With("remote", wsConn.RemoteAddr())
+	logger := wm.logger.With("remote", wsConn.RemoteAddr(), "remote_hash", hashRemoteAddr(wsConn.RemoteAddr().String()))
 	conn := newWSConnection(wsConn, wm.funcMap, logger, wm.wsConnOptions...)
+	conn.identity = identity
+	conn.authorizer = wm.authorizer
+	conn.metrics = wm.metrics
+	// The subprotocol, if any, was already negotiated by Upgrade against
+	// wm.Subprotocols; codecForSubprotocol falls back to JSON for clients
+	// that didn't request one.
+	conn.codec = codecForSubprotocol(wsConn.Subprotocol())
+	if err := wsConn.SetCompressionLevel(conn.compressionLevel); err != nil {
+		wm.logger.Error("failed to set websocket compression level", "err", err)
+	}
+	conn.metrics.Upgrades.Inc(1)
 	wm.logger.Info("New websocket connection", "remote", conn.remoteAddr)
 
 	// starting the conn is blocking
@@ -114,6 +178,10 @@ type wsConnection struct {
 	baseConn   *websocket.Conn
 	// writeChan is never closed, to allow WriteRPCResponse() to fail.
 	writeChan chan rpctypes.RPCResponse
+	// batchWriteChan carries the responses to a JSON-RPC batch request,
+	// written back as a single array frame encoded by codec. Never closed, for the same
+	// reason as writeChan.
+	batchWriteChan chan []rpctypes.RPCResponse
 
 	// chan, which is closed when/if readRoutine errors
 	// used to abort writeRoutine
@@ -136,11 +204,48 @@ type wsConnection struct {
 	// Maximum message size.
 	readLimit int64
 
+	// Maximum number of requests accepted in a single JSON-RPC batch. 0
+	// (the default) means unbounded.
+	batchLimit int
+
 	// callback which is called upon disconnect
 	onDisconnect func(remoteAddr string)
 
-	ctx    context.Context
-	cancel context.CancelFunc
+	// backpressure governs what happens when writeChan can't keep up with
+	// outgoing responses. Defaults to blocking, the historical behavior.
+	backpressure BackpressurePolicyConfig
+	// backpressureMu guards slowSince.
+	backpressureMu sync.Mutex
+	// slowSince is when writeChan's depth was first observed at or above
+	// backpressure.threshold, or the zero value if it currently isn't.
+	// Only meaningful under BackpressureMode DisconnectSlow.
+	slowSince time.Time
+	// onSlowConsumer is called when DisconnectSlow disconnects a client.
+	onSlowConsumer func(remoteAddr string, queueDepth int)
+
+	// metrics records connection, request and backpressure telemetry. Nop by
+	// default.
+	metrics *Metrics
+
+	// codec encodes responses and decodes requests for this connection. It
+	// is negotiated once, in WebsocketHandler, from the Sec-WebSocket-Protocol
+	// the client requested at Upgrade; newWSConnection defaults it to JSON so
+	// that a connection it builds outside WebsocketHandler still works.
+	codec wsCodec
+	// compressionLevel is passed to the underlying websocket.Conn via
+	// SetCompressionLevel once the connection is upgraded. Only takes effect
+	// if the client negotiated permessage-deflate.
+	compressionLevel int
+
+	// identity is the caller identity established by the WebsocketManager's
+	// authenticator at upgrade time, or nil if none was configured.
+	identity Identity
+	// authorizer, if set, is checked before every RPC dispatch.
+	authorizer Authorizer
+
+	ctxOnce sync.Once
+	ctx     context.Context
+	cancel  context.CancelFunc
 }
 
 // NewWSConnection wraps websocket.Conn.
@@ -165,6 +270,9 @@ func newWSConnection(
 		readWait:          defaultWSReadWait,
 		pingPeriod:        defaultWSPingPeriod,
 		readRoutineQuit:   make(chan struct{}),
+		metrics:           NopMetrics(),
+		codec:             jsonCodec{},
+		compressionLevel:  flate.DefaultCompression,
 	}
 	for _, option := range options {
 		option(wsc)
@@ -221,12 +329,36 @@ func ReadLimit(readLimit int64) func(*wsConnection) {
 	}
 }
 
+// CompressionLevel sets the permessage-deflate compression level used once
+// the client has negotiated compression at Upgrade, following the levels
+// defined by compress/flate (flate.BestSpeed to flate.BestCompression, or
+// flate.DefaultCompression). It has no effect on clients that don't request
+// compression. It should only be used in the constructor - not
+// Goroutine-safe.
+func CompressionLevel(level int) func(*wsConnection) {
+	return func(wsc *wsConnection) {
+		wsc.compressionLevel = level
+	}
+}
+
+// BatchLimit sets the maximum number of requests accepted in a single
+// JSON-RPC batch. Batches larger than this are rejected with an error
+// response rather than dispatched. It should only be used in the
+// constructor - not Goroutine-safe. 0 (the default) means unbounded.
+func BatchLimit(batchLimit int) func(*wsConnection) {
+	return func(wsc *wsConnection) {
+		wsc.batchLimit = batchLimit
+	}
+}
+
 // Start starts the client service routines and blocks until there is an error.
 func (wsc *wsConnection) Start(ctx context.Context) error {
 	if err := wsc.RunState.Start(ctx); err != nil {
 		return err
 	}
 	wsc.writeChan = make(chan rpctypes.RPCResponse, wsc.writeChanCapacity)
+	wsc.batchWriteChan = make(chan []rpctypes.RPCResponse, wsc.writeChanCapacity)
+	wsc.metrics.ActiveConnections.Inc(1)
 
 	// Read subscriptions/unsubscriptions to events
 	go wsc.readRoutine(ctx)
@@ -241,12 +373,13 @@ func (wsc *wsConnection) Stop() error {
 	if err := wsc.RunState.Stop(); err != nil {
 		return err
 	}
+	wsc.metrics.ActiveConnections.Dec(1)
+	wsc.metrics.Disconnects.Inc(1)
 	if wsc.onDisconnect != nil {
 		wsc.onDisconnect(wsc.remoteAddr)
 	}
-	if wsc.ctx != nil {
-		wsc.cancel()
-	}
+	wsc.Context()
+	wsc.cancel()
 	return nil
 }
 
@@ -256,15 +389,58 @@ func (wsc *wsConnection) GetRemoteAddr() string {
 	return wsc.remoteAddr
 }
 
-// WriteRPCResponse pushes a response to the writeChan, and blocks until it is
-// accepted.
+// writeErrorResponse marks resp's error code in metrics, mirroring the
+// accounting handleRequest does for responses built along its own dispatch
+// path, and writes it back. Use this for error responses built directly in
+// readRoutine/handleBatchRequest, which never go through handleRequest.
+func (wsc *wsConnection) writeErrorResponse(ctx context.Context, resp rpctypes.RPCResponse) {
+	if resp.Error != nil {
+		wsc.metrics.MarkError(resp.Error.Code)
+	}
+	if err := wsc.WriteRPCResponse(ctx, resp); err != nil {
+		wsc.Logger.Error("error writing RPC response", "err", err)
+	}
+}
+
+// WriteRPCResponse pushes a response to the writeChan, honoring the
+// connection's BackpressurePolicy: by default it blocks until accepted (or
+// ctx is done); under DropOldest it never blocks; under DisconnectSlow it
+// never blocks and may eventually disconnect the client.
 // It implements WSRPCConnection. It is Goroutine-safe.
 func (wsc *wsConnection) WriteRPCResponse(ctx context.Context, resp rpctypes.RPCResponse) error {
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case wsc.writeChan <- resp:
+	switch wsc.backpressure.mode {
+	case backpressureDropOldest:
+		wsc.pushDropOldest(resp)
 		return nil
+
+	case backpressureDisconnectSlow:
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case wsc.writeChan <- resp:
+			depth := len(wsc.writeChan)
+			wsc.metrics.WriteChanDepth.Update(int64(depth))
+			wsc.checkSlowConsumer(depth)
+			return nil
+		default:
+			wsc.metrics.DroppedResponses.Inc(1)
+			wsc.checkSlowConsumer(wsc.writeChanCapacity)
+			return nil
+		}
+
+	default: // backpressureBlock
+		if d := wsc.backpressure.timeout; d > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, d)
+			defer cancel()
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case wsc.writeChan <- resp:
+			wsc.metrics.WriteChanDepth.Update(int64(len(wsc.writeChan)))
+			return nil
+		}
 	}
 }
 
@@ -272,26 +448,89 @@ func (wsc *wsConnection) WriteRPCResponse(ctx context.Context, resp rpctypes.RPC
 // not block.
 // It implements WSRPCConnection. It is Goroutine-safe
 func (wsc *wsConnection) TryWriteRPCResponse(ctx context.Context, resp rpctypes.RPCResponse) bool {
+	if wsc.backpressure.mode == backpressureDropOldest {
+		wsc.pushDropOldest(resp)
+		return true
+	}
 	select {
 	case <-ctx.Done():
 		return false
 	case wsc.writeChan <- resp:
+		depth := len(wsc.writeChan)
+		wsc.metrics.WriteChanDepth.Update(int64(depth))
+		if wsc.backpressure.mode == backpressureDisconnectSlow {
+			wsc.checkSlowConsumer(depth)
+		}
 		return true
 	default:
+		wsc.metrics.DroppedResponses.Inc(1)
+		if wsc.backpressure.mode == backpressureDisconnectSlow {
+			wsc.checkSlowConsumer(wsc.writeChanCapacity)
+		}
 		return false
 	}
 }
 
+// WriteRPCBatchResponse pushes the responses to a JSON-RPC batch request to
+// batchWriteChan, to be written back as a single array frame in the
+// connection's codec, honoring
+// the connection's BackpressurePolicy the same way WriteRPCResponse does.
+// It is Goroutine-safe.
+func (wsc *wsConnection) WriteRPCBatchResponse(ctx context.Context, resps []rpctypes.RPCResponse) error {
+	switch wsc.backpressure.mode {
+	case backpressureDropOldest:
+		wsc.pushDropOldestBatch(resps)
+		return nil
+
+	case backpressureDisconnectSlow:
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case wsc.batchWriteChan <- resps:
+			depth := len(wsc.batchWriteChan)
+			wsc.metrics.WriteChanDepth.Update(int64(depth))
+			wsc.checkSlowConsumer(depth)
+			return nil
+		default:
+			wsc.metrics.DroppedResponses.Inc(1)
+			wsc.checkSlowConsumer(wsc.writeChanCapacity)
+			return nil
+		}
+
+	default: // backpressureBlock
+		if d := wsc.backpressure.timeout; d > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, d)
+			defer cancel()
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case wsc.batchWriteChan <- resps:
+			wsc.metrics.WriteChanDepth.Update(int64(len(wsc.batchWriteChan)))
+			return nil
+		}
+	}
+}
+
 // Context returns the connection's context.
-// The context is canceled when the client's connection closes.
+// The context is canceled when the client's connection closes. Goroutine-safe:
+// the underlying context/cancel pair is created at most once, even if called
+// concurrently from a publisher goroutine and the connection's own readRoutine.
 func (wsc *wsConnection) Context() context.Context {
-	if wsc.ctx != nil {
-		return wsc.ctx
-	}
-	wsc.ctx, wsc.cancel = context.WithCancel(context.Background())
+	wsc.ctxOnce.Do(func() {
+		wsc.ctx, wsc.cancel = context.WithCancel(context.Background())
+	})
 	return wsc.ctx
 }
 
+// Identity returns the caller identity established by the
+// WebsocketManager's authenticator, or nil if none was configured. RPC
+// handlers reach it through rpctypes.Context.WSConn.
+func (wsc *wsConnection) Identity() Identity {
+	return wsc.identity
+}
+
 // Read from the socket and subscribe to or unsubscribe from events
 func (wsc *wsConnection) readRoutine(ctx context.Context) {
 	// readRoutine will block until response is written or WS connection is closed
@@ -303,10 +542,9 @@ func (wsc *wsConnection) readRoutine(ctx context.Context) {
 			if !ok {
 				err = fmt.Errorf("WSJSONRPC: %v", r)
 			}
+			wsc.metrics.Panics.Inc(1)
 			wsc.Logger.Error("Panic in WSJSONRPC handler", "err", err, "stack", string(debug.Stack()))
-			if err := wsc.WriteRPCResponse(writeCtx, rpctypes.RPCInternalError(rpctypes.JSONRPCIntID(-1), err)); err != nil {
-				wsc.Logger.Error("error writing RPC response", "err", err)
-			}
+			wsc.writeErrorResponse(writeCtx, rpctypes.RPCInternalError(rpctypes.JSONRPCIntID(-1), err))
 			go wsc.readRoutine(ctx)
 		}
 	}()
@@ -330,6 +568,9 @@ func (wsc *wsConnection) readRoutine(ctx context.Context) {
 				if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
 					wsc.Logger.Info("Client closed the connection")
 				} else {
+					if ne, ok := err.(net.Error); ok && ne.Timeout() {
+						wsc.metrics.PongTimeouts.Inc(1)
+					}
 					wsc.Logger.Error("Failed to read request", "err", err)
 				}
 				if err := wsc.Stop(); err != nil {
@@ -339,86 +580,185 @@ func (wsc *wsConnection) readRoutine(ctx context.Context) {
 				return
 			}
 
-			dec := json.NewDecoder(r)
-			var request rpctypes.RPCRequest
-			err = dec.Decode(&request)
+			data, err := io.ReadAll(r)
 			if err != nil {
-				if err := wsc.WriteRPCResponse(writeCtx,
-					rpctypes.RPCParseError(fmt.Errorf("error unmarshaling request: %w", err))); err != nil {
-					wsc.Logger.Error("error writing RPC response", "err", err)
+				wsc.writeErrorResponse(writeCtx, rpctypes.RPCParseError(fmt.Errorf("error reading request: %w", err)))
+				continue
+			}
+
+			// A JSON-RPC 2.0 batch request is a top-level array rather than
+			// a single request object.
+			if wsc.codec.IsBatch(data) {
+				var requests []rpctypes.RPCRequest
+				if err := wsc.codec.Unmarshal(data, &requests); err != nil {
+					wsc.writeErrorResponse(writeCtx,
+						rpctypes.RPCParseError(fmt.Errorf("error unmarshaling batch request: %w", err)))
+					continue
 				}
+				wsc.handleBatchRequest(writeCtx, requests)
 				continue
 			}
 
-			// A Notification is a Request object without an "id" member.
-			// The Server MUST NOT reply to a Notification, including those that are within a batch request.
-			if request.ID == nil {
-				wsc.Logger.Debug(
-					"WSJSONRPC received a notification, skipping... (please send a non-empty ID if you want to call a method)",
-					"req", request,
-				)
+			var request rpctypes.RPCRequest
+			if err := wsc.codec.Unmarshal(data, &request); err != nil {
+				wsc.writeErrorResponse(writeCtx, rpctypes.RPCParseError(fmt.Errorf("error unmarshaling request: %w", err)))
 				continue
 			}
 
-			// Now, fetch the RPCFunc and execute it.
-			rpcFunc := wsc.funcMap[request.Method]
-			if rpcFunc == nil {
-				if err := wsc.WriteRPCResponse(writeCtx, rpctypes.RPCMethodNotFoundError(request.ID)); err != nil {
-					wsc.Logger.Error("error writing RPC response", "err", err)
-				}
+			resp := wsc.handleRequest(request)
+			if resp == nil {
+				// a notification: the Server MUST NOT reply
 				continue
 			}
+			if err := wsc.WriteRPCResponse(writeCtx, *resp); err != nil {
+				wsc.Logger.Error("error writing RPC response", "err", err)
+			}
+		}
+	}
+}
 
-			ctx := &rpctypes.Context{JSONReq: &request, WSConn: wsc}
-			args := []reflect.Value{reflect.ValueOf(ctx)}
-			if len(request.Params) > 0 {
-				fnArgs, err := jsonParamsToArgs(rpcFunc, request.Params)
-				if err != nil {
-					if err := wsc.WriteRPCResponse(writeCtx,
-						rpctypes.RPCInvalidParamsError(request.ID, fmt.Errorf("error converting json params to arguments: %w", err)),
-					); err != nil {
-						wsc.Logger.Error("error writing RPC response", "err", err)
-					}
-					continue
-				}
-				args = append(args, fnArgs...)
+// isBatchRequest reports whether data is a JSON-RPC 2.0 batch request, i.e.
+// its first non-whitespace byte is '[' rather than '{'.
+func isBatchRequest(data []byte) bool {
+	for _, b := range data {
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		default:
+			return b == '['
+		}
+	}
+	return false
+}
+
+// handleRequest dispatches a single RPC request and returns the response to
+// write back, or nil if request is a notification (which must not be
+// answered). A panic inside the handler is recovered here so that one bad
+// request within a batch cannot take down its siblings; readRoutine's own
+// recover remains in place for panics elsewhere in the read loop.
+func (wsc *wsConnection) handleRequest(request rpctypes.RPCRequest) (resp *rpctypes.RPCResponse) {
+	defer func() {
+		if r := recover(); r != nil {
+			err, ok := r.(error)
+			if !ok {
+				err = fmt.Errorf("WSJSONRPC: %v", r)
 			}
+			wsc.metrics.Panics.Inc(1)
+			wsc.Logger.Error("Panic in WSJSONRPC handler", "err", err, "stack", string(debug.Stack()))
+			errResp := rpctypes.RPCInternalError(request.ID, err)
+			resp = &errResp
+		}
+		// Every error response this function can return, whichever branch
+		// built it, is marked here in one place rather than at each call site.
+		if resp != nil && resp.Error != nil {
+			wsc.metrics.MarkError(resp.Error.Code)
+		}
+	}()
 
-			returns := rpcFunc.f.Call(args)
+	// A Notification is a Request object without an "id" member.
+	// The Server MUST NOT reply to a Notification, including those that are within a batch request.
+	if request.ID == nil {
+		wsc.Logger.Debug(
+			"WSJSONRPC received a notification, skipping... (please send a non-empty ID if you want to call a method)",
+			"req", request,
+		)
+		return nil
+	}
 
-			// TODO: Need to encode args/returns to string if we want to log them
-			wsc.Logger.Info("WSJSONRPC", "method", request.Method)
+	// Now, fetch the RPCFunc and execute it.
+	rpcFunc := wsc.funcMap[request.Method]
+	if rpcFunc == nil {
+		errResp := rpctypes.RPCMethodNotFoundError(request.ID)
+		return &errResp
+	}
 
-			var resp rpctypes.RPCResponse
-			result, err := unreflectResult(returns)
-			switch e := err.(type) {
-			// if no error then return a success response
-			case nil:
-				resp = rpctypes.NewRPCSuccessResponse(request.ID, result)
+	if wsc.authorizer != nil {
+		if err := wsc.authorizer(wsc.identity, request.Method); err != nil {
+			errResp := rpctypes.NewRPCErrorResponse(request.ID, CodeUnauthorized, "Unauthorized", err.Error())
+			return &errResp
+		}
+	}
 
-			// if this already of type RPC error then forward that error
-			case *rpctypes.RPCError:
-				resp = rpctypes.NewRPCErrorResponse(request.ID, e.Code, e.Message, e.Data)
+	rpcCtx := &rpctypes.Context{JSONReq: &request, WSConn: wsc}
+	args := []reflect.Value{reflect.ValueOf(rpcCtx)}
+	if len(request.Params) > 0 {
+		fnArgs, err := jsonParamsToArgs(rpcFunc, request.Params)
+		if err != nil {
+			errResp := rpctypes.RPCInvalidParamsError(request.ID,
+				fmt.Errorf("error converting json params to arguments: %w", err))
+			return &errResp
+		}
+		args = append(args, fnArgs...)
+	}
 
-			default: // we need to unwrap the error and parse it accordingly
-				switch errors.Unwrap(err) {
-				// check if the error was due to an invald request
-				case coretypes.ErrZeroOrNegativeHeight, coretypes.ErrZeroOrNegativePerPage,
-					coretypes.ErrPageOutOfRange, coretypes.ErrInvalidRequest:
-					resp = rpctypes.RPCInvalidRequestError(request.ID, err)
+	wsc.metrics.MarkRequest(request.Method)
+	callStart := time.Now()
+	returns := rpcFunc.f.Call(args)
+	wsc.metrics.MarkRequestLatency(time.Since(callStart))
+
+	// TODO: Need to encode args/returns to string if we want to log them
+	wsc.Logger.Info("WSJSONRPC", "method", request.Method)
+
+	var out rpctypes.RPCResponse
+	result, err := unreflectResult(returns)
+	switch e := err.(type) {
+	// if no error then return a success response
+	case nil:
+		out = rpctypes.NewRPCSuccessResponse(request.ID, result)
+
+	// if this already of type RPC error then forward that error
+	case *rpctypes.RPCError:
+		out = rpctypes.NewRPCErrorResponse(request.ID, e.Code, e.Message, e.Data)
+
+	default: // we need to unwrap the error and parse it accordingly
+		switch errors.Unwrap(err) {
+		// check if the error was due to an invald request
+		case coretypes.ErrZeroOrNegativeHeight, coretypes.ErrZeroOrNegativePerPage,
+			coretypes.ErrPageOutOfRange, coretypes.ErrInvalidRequest:
+			out = rpctypes.RPCInvalidRequestError(request.ID, err)
+
+		// lastly default all remaining errors as internal errors
+		default: // includes ctypes.ErrHeightNotAvailable and ctypes.ErrHeightExceedsChainHead
+			out = rpctypes.RPCInternalError(request.ID, err)
+		}
+	}
 
-				// lastly default all remaining errors as internal errors
-				default: // includes ctypes.ErrHeightNotAvailable and ctypes.ErrHeightExceedsChainHead
-					resp = rpctypes.RPCInternalError(request.ID, err)
-				}
-			}
+	return &out
+}
 
-			if err := wsc.WriteRPCResponse(writeCtx, resp); err != nil {
-				wsc.Logger.Error("error writing RPC response", "err", err)
-			}
+// handleBatchRequest dispatches every request in a JSON-RPC batch and writes
+// the collected responses back as a single array frame, encoded by the
+// connection's codec, in the order
+// the requests arrived. Notifications contribute no entry to the response.
+// Per the JSON-RPC 2.0 spec, a batch made up solely of notifications gets no
+// response at all, but a syntactically empty batch ("[]") is itself an
+// invalid request and gets a single Invalid Request response.
+func (wsc *wsConnection) handleBatchRequest(writeCtx context.Context, requests []rpctypes.RPCRequest) {
+	if len(requests) == 0 {
+		wsc.writeErrorResponse(writeCtx, rpctypes.RPCInvalidRequestError(nil, errors.New("empty batch")))
+		return
+	}
+
+	if wsc.batchLimit > 0 && len(requests) > wsc.batchLimit {
+		wsc.writeErrorResponse(writeCtx, rpctypes.RPCInvalidRequestError(nil,
+			fmt.Errorf("batch of %d requests exceeds the maximum of %d", len(requests), wsc.batchLimit)))
+		return
+	}
 
+	responses := make([]rpctypes.RPCResponse, 0, len(requests))
+	for _, request := range requests {
+		if resp := wsc.handleRequest(request); resp != nil {
+			responses = append(responses, *resp)
 		}
 	}
+
+	if len(responses) == 0 {
+		return
+	}
+
+	if err := wsc.WriteRPCBatchResponse(writeCtx, responses); err != nil {
+		wsc.Logger.Error("error writing RPC batch response", "err", err)
+	}
 }
 
 // receives on a write channel and writes out on the socket
@@ -450,19 +790,30 @@ func (wsc *wsConnection) writeRoutine(ctx context.Context) {
 		case <-pingTicker.C:
 			err := wsc.writeMessageWithDeadline(websocket.PingMessage, []byte{})
 			if err != nil {
+				wsc.metrics.PingWriteErrors.Inc(1)
 				wsc.Logger.Error("Failed to write ping", "err", err)
 				return
 			}
 		case msg := <-wsc.writeChan:
-			jsonBytes, err := json.MarshalIndent(msg, "", "  ")
+			encoded, err := wsc.codec.Marshal(msg)
 			if err != nil {
-				wsc.Logger.Error("Failed to marshal RPCResponse to JSON", "err", err)
+				wsc.Logger.Error("Failed to encode RPCResponse", "err", err)
 				continue
 			}
-			if err = wsc.writeMessageWithDeadline(websocket.TextMessage, jsonBytes); err != nil {
+			if err = wsc.writeMessageWithDeadline(wsc.codec.MessageType(), encoded); err != nil {
 				wsc.Logger.Error("Failed to write response", "err", err, "msg", msg)
 				return
 			}
+		case msgs := <-wsc.batchWriteChan:
+			encoded, err := wsc.codec.Marshal(msgs)
+			if err != nil {
+				wsc.Logger.Error("Failed to encode RPCResponse batch", "err", err)
+				continue
+			}
+			if err = wsc.writeMessageWithDeadline(wsc.codec.MessageType(), encoded); err != nil {
+				wsc.Logger.Error("Failed to write batch response", "err", err, "msgs", msgs)
+				return
+			}
 		}
 	}
 }